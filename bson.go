@@ -95,8 +95,33 @@ type fieldInfo struct {
 	OmitEmpty bool
 	MinSize   bool
 	Inline    []int
+
+	// Op holds the update operator the field should be written
+	// through, one of updateOp* below, or the empty string for the
+	// default $set/$unset behaviour.
+	Op string
+
+	// Rules holds the compiled "validate" tag rules for the field,
+	// if any. See validate.go.
+	Rules []validateRule
 }
 
+// Update operators that may be selected via a bson tag flag, routing
+// a field to the corresponding map on Update instead of Set/Unset.
+const (
+	updateOpInc         = "inc"
+	updateOpPush        = "push"
+	updateOpPull        = "pull"
+	updateOpAddToSet    = "addToSet"
+	updateOpCurrentDate = "currentDate"
+
+	// updateOpVersion marks the optimistic-concurrency field: the
+	// update increments it by one via $inc instead of $set, and its
+	// observed value is reported in Update.Selector for the caller
+	// to combine with its own _id filter.
+	updateOpVersion = "version"
+)
+
 var structMap = make(map[reflect.Type]*structInfo)
 var structMapMutex sync.RWMutex
 
@@ -125,6 +150,14 @@ func getStructInfo(st reflect.Type) (*structInfo, error) {
 
 		info := fieldInfo{Num: i}
 
+		if vtag := field.Tag.Get("validate"); vtag != "" {
+			rules, err := parseValidateTag(vtag)
+			if err != nil {
+				return nil, errors.New("field " + field.Name + " of struct " + st.String() + ": " + err.Error())
+			}
+			info.Rules = rules
+		}
+
 		tag := field.Tag.Get("bson")
 		if tag == "" && strings.Index(string(field.Tag), ":") < 0 {
 			tag = string(field.Tag)
@@ -144,6 +177,8 @@ func getStructInfo(st reflect.Type) (*structInfo, error) {
 					info.MinSize = true
 				case "inline":
 					inline = true
+				case updateOpInc, updateOpPush, updateOpPull, updateOpAddToSet, updateOpCurrentDate, updateOpVersion:
+					info.Op = flag
 				default:
 					msg := fmt.Sprintf("Unsupported flag %q in tag %q of type %s", flag, tag, st)
 					panic(externalPanic(msg))
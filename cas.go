@@ -0,0 +1,72 @@
+// Copyright (c) 2016 - Canonical Ltd
+//
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+//    list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+// ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package mgoutil
+
+import (
+	"errors"
+
+	"gopkg.in/errgo.v1"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// ErrConflict is returned by UpdateWithCAS when the document's
+// version field no longer matches the observed value, meaning
+// another writer updated it concurrently.
+var ErrConflict = errors.New("update conflicts with a concurrent change")
+
+// UpdateWithCAS updates the document in coll with the given id using
+// AsUpdate(doc), enforcing optimistic concurrency via doc's "version"
+// tagged field (see AsUpdate). It combines the resulting Update's
+// Selector with the _id filter so the write only takes effect if the
+// version field still holds its observed value, and returns
+// ErrConflict if no document matched that selector, so callers can
+// retry their read-modify-write loop.
+func UpdateWithCAS(coll *mgo.Collection, id, doc interface{}) error {
+	u, err := AsUpdate(doc)
+	if err != nil {
+		return errgo.Notef(err, "cannot build update")
+	}
+	err = coll.Update(casSelector(id, u.Selector), u)
+	if err == mgo.ErrNotFound {
+		return ErrConflict
+	}
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	return nil
+}
+
+// casSelector returns the selector used by UpdateWithCAS: the given
+// _id combined with the observed values in sel, so the update only
+// takes effect if every version field in sel still holds the value
+// it was read at.
+func casSelector(id interface{}, sel bson.M) bson.M {
+	selector := bson.M{"_id": id}
+	for k, v := range sel {
+		selector[k] = v
+	}
+	return selector
+}
@@ -0,0 +1,47 @@
+// Copyright (c) 2016 - Canonical Ltd
+//
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+//    list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+// ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package mgoutil
+
+import (
+	"testing"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+func TestCASSelector(t *testing.T) {
+	got := casSelector("abc123", bson.M{"rev": 4})
+	want := bson.M{"_id": "abc123", "rev": 4}
+	if len(got) != len(want) || got["_id"] != want["_id"] || got["rev"] != want["rev"] {
+		t.Fatalf("casSelector(%q, %v) = %v, want %v", "abc123", bson.M{"rev": 4}, got, want)
+	}
+}
+
+func TestCASSelectorNoVersionField(t *testing.T) {
+	got := casSelector("abc123", nil)
+	want := bson.M{"_id": "abc123"}
+	if len(got) != len(want) || got["_id"] != want["_id"] {
+		t.Fatalf("casSelector(%q, nil) = %v, want %v", "abc123", got, want)
+	}
+}
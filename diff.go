@@ -0,0 +1,345 @@
+// Copyright (c) 2016 - Canonical Ltd
+//
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+//    list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+// ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package mgoutil
+
+import (
+	"bytes"
+	"reflect"
+
+	"gopkg.in/errgo.v1"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// ChangeType describes the kind of change a Change represents.
+type ChangeType string
+
+const (
+	// ChangeCreate means the field was absent (or omitted as a
+	// zero value) in the old document and present in the new one.
+	ChangeCreate ChangeType = "create"
+
+	// ChangeUpdate means the field is present in both documents
+	// but its value differs.
+	ChangeUpdate ChangeType = "update"
+
+	// ChangeDelete means the field was present in the old document
+	// and became a zero omitempty value, or disappeared from an
+	// inline map, in the new one.
+	ChangeDelete ChangeType = "delete"
+)
+
+// Change describes a single field-level difference found by
+// DiffAsUpdate. Path holds the dotted field path, expressed as the
+// individual bson key at each level; for the fields of mgoutil, which
+// does not currently recurse into non-inline nested structs, this is
+// always a single-element slice.
+//
+// An operator-tagged field (see AsUpdate) is always reported as a
+// ChangeUpdate, regardless of whether old and new differ, since the
+// operator describes an action to apply rather than a value to
+// compare; To holds the operator's argument (1 for "version", true
+// for "currentDate") and From is left unset.
+type Change struct {
+	Type ChangeType
+	Path []string
+	From interface{}
+	To   interface{}
+}
+
+// Changelog returns the field-level changes that produced u. It
+// returns nil unless u was built by DiffAsUpdate.
+func (u Update) Changelog() []Change {
+	return u.changes
+}
+
+// DiffAsUpdate compares old and new, which must be acceptable to
+// bson.Marshal and either both resolve to the same struct type or
+// both resolve to maps, and returns an Update containing only the
+// fields whose values actually differ: changed or newly-present
+// fields are placed in Set, and fields that became a zero omitempty
+// value (or that disappeared from an inline map) are placed in
+// Unset. Fields whose value is unchanged are omitted from both.
+//
+// Like AsUpdate, DiffAsUpdate recurses into ",inline" struct fields
+// (an embedded struct is recursed into only when tagged ",inline"),
+// honours the "omitempty" bson tag flag, and omits the _id field from
+// the result. The "minsize" flag is not applied: values are placed in
+// Set with their Go types unchanged. Leaf values are compared with
+// reflect.DeepEqual, except bson.Raw and bson.Getter implementations,
+// which are compared by marshaling both sides to BSON and comparing
+// the resulting bytes, so differences in representation rather than
+// content don't cause spurious updates.
+//
+// A field whose bson tag names an operator flag ("inc", "push",
+// "pull", "addToSet", "currentDate" or "version") is never compared
+// for equality: it is always routed to the matching operator map on
+// the returned Update, exactly as AsUpdate(new) would, since those
+// operators describe an action to apply rather than a value to set.
+// For a "version" field, the Selector entry is taken from old, since
+// that is the value actually stored in Mongo that the CAS guard must
+// match.
+//
+// Before diffing, DiffAsUpdate validates new the same way AsUpdate
+// does: see AsUpdate's Validator and RegisterValidator documentation.
+//
+// The returned Update's Changelog method reports the individual
+// field-level changes that were found, so callers can audit or log
+// what is about to change before submitting it to Mongo.
+func DiffAsUpdate(old, new interface{}) (Update, error) {
+	ov, err := resolveValue(old)
+	if err != nil {
+		return Update{}, err
+	}
+	nv, err := resolveValue(new)
+	if err != nil {
+		return Update{}, err
+	}
+	if err := runValidators(nv); err != nil {
+		return Update{}, err
+	}
+	if ov.Type() != nv.Type() {
+		return Update{}, errgo.Newf("cannot diff values of different types: %s != %s", ov.Type(), nv.Type())
+	}
+	if nv.Type() == typeRaw {
+		return nonStructDiffAsUpdate(ov.Addr(), nv.Addr())
+	}
+	switch nv.Kind() {
+	case reflect.Map:
+		return mapDiffAsUpdate(ov, nv)
+	case reflect.Struct:
+		return structDiffAsUpdate(ov, nv)
+	default:
+		return nonStructDiffAsUpdate(ov, nv)
+	}
+}
+
+func structDiffAsUpdate(ov, nv reflect.Value) (Update, error) {
+	sinfo, err := getStructInfo(nv.Type())
+	if err != nil {
+		return Update{}, err
+	}
+	u := Update{
+		Set:   make(bson.M),
+		Unset: make(bson.M),
+	}
+	if sinfo.InlineMap >= 0 {
+		om, nm := ov.Field(sinfo.InlineMap), nv.Field(sinfo.InlineMap)
+		seen := make(map[string]bool)
+		if nm.Len() != 0 {
+			for _, k := range nm.MapKeys() {
+				ks := k.String()
+				if _, found := sinfo.FieldsMap[ks]; found {
+					return Update{}, errgo.Newf("Can't have key %q in inlined map; conflicts with struct field", ks)
+				}
+				if ks == "_id" {
+					continue
+				}
+				seen[ks] = true
+				nval := nm.MapIndex(k).Interface()
+				if oe := om.MapIndex(k); oe.IsValid() {
+					equal, err := bsonLeafEqual(oe.Interface(), nval)
+					if err != nil {
+						return Update{}, err
+					}
+					if !equal {
+						u.Set[ks] = nval
+						u.changes = append(u.changes, Change{Type: ChangeUpdate, Path: []string{ks}, From: oe.Interface(), To: nval})
+					}
+					continue
+				}
+				u.Set[ks] = nval
+				u.changes = append(u.changes, Change{Type: ChangeCreate, Path: []string{ks}, To: nval})
+			}
+		}
+		for _, k := range om.MapKeys() {
+			ks := k.String()
+			if ks == "_id" || seen[ks] {
+				continue
+			}
+			u.Unset[ks] = nil
+			u.changes = append(u.changes, Change{Type: ChangeDelete, Path: []string{ks}, From: om.MapIndex(k).Interface()})
+		}
+	}
+
+	var ofield, nfield reflect.Value
+	for _, info := range sinfo.FieldsList {
+		if info.Key == "_id" {
+			continue
+		}
+		if info.Inline == nil {
+			ofield, nfield = ov.Field(info.Num), nv.Field(info.Num)
+		} else {
+			ofield, nfield = ov.FieldByIndex(info.Inline), nv.FieldByIndex(info.Inline)
+		}
+		if info.Op != "" {
+			arg := applyDiffOp(&u, info, ofield, nfield)
+			u.changes = append(u.changes, Change{Type: ChangeUpdate, Path: []string{info.Key}, To: arg})
+			continue
+		}
+		oldAbsent := info.OmitEmpty && isZero(ofield)
+		newAbsent := info.OmitEmpty && isZero(nfield)
+		switch {
+		case oldAbsent && newAbsent:
+			// Absent on both sides; nothing to report.
+		case oldAbsent && !newAbsent:
+			u.Set[info.Key] = nfield.Interface()
+			u.changes = append(u.changes, Change{Type: ChangeCreate, Path: []string{info.Key}, To: nfield.Interface()})
+		case !oldAbsent && newAbsent:
+			u.Unset[info.Key] = nil
+			u.changes = append(u.changes, Change{Type: ChangeDelete, Path: []string{info.Key}, From: ofield.Interface()})
+		default:
+			equal, err := bsonLeafEqual(ofield.Interface(), nfield.Interface())
+			if err != nil {
+				return Update{}, err
+			}
+			if !equal {
+				u.Set[info.Key] = nfield.Interface()
+				u.changes = append(u.changes, Change{Type: ChangeUpdate, Path: []string{info.Key}, From: ofield.Interface(), To: nfield.Interface()})
+			}
+		}
+	}
+	return u, nil
+}
+
+// applyDiffOp routes an operator-tagged field encountered by
+// structDiffAsUpdate to the matching operator map on u via applyOp,
+// the same routing structAsUpdate uses: the value of new supplies the
+// operator's argument, since an operator field describes an action to
+// apply rather than a value to compare. The "version" tag is the
+// exception, since its Selector entry must reflect the value actually
+// stored in Mongo, i.e. old's. It returns the operator argument
+// applied, as reported by applyOp, so the caller can log it in the
+// changelog.
+func applyDiffOp(u *Update, info fieldInfo, ofield, nfield reflect.Value) interface{} {
+	return applyOp(u, info, nfield.Interface(), ofield.Interface())
+}
+
+func nonStructDiffAsUpdate(ov, nv reflect.Value) (Update, error) {
+	om, err := marshalToRawMap(ov)
+	if err != nil {
+		return Update{}, err
+	}
+	nm, err := marshalToRawMap(nv)
+	if err != nil {
+		return Update{}, err
+	}
+	return mapDiffAsUpdate(reflect.ValueOf(om), reflect.ValueOf(nm))
+}
+
+func marshalToRawMap(v reflect.Value) (map[string]bson.Raw, error) {
+	data, err := bson.Marshal(v.Interface())
+	if err != nil {
+		return nil, errgo.Notef(err, "cannot marshal")
+	}
+	var m map[string]bson.Raw
+	if err := bson.Unmarshal(data, &m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func mapDiffAsUpdate(ov, nv reflect.Value) (Update, error) {
+	if nv.Type().Key().Kind() != reflect.String {
+		return Update{}, errgo.Newf("map key not a string")
+	}
+	u := Update{
+		Set:   make(bson.M),
+		Unset: make(bson.M),
+	}
+	seen := make(map[string]bool)
+	for _, k := range nv.MapKeys() {
+		ks := k.String()
+		if ks == "_id" {
+			continue
+		}
+		seen[ks] = true
+		nval := nv.MapIndex(k).Interface()
+		if ov.IsValid() {
+			if oe := ov.MapIndex(k); oe.IsValid() {
+				equal, err := bsonLeafEqual(oe.Interface(), nval)
+				if err != nil {
+					return Update{}, err
+				}
+				if !equal {
+					u.Set[ks] = nval
+					u.changes = append(u.changes, Change{Type: ChangeUpdate, Path: []string{ks}, From: oe.Interface(), To: nval})
+				}
+				continue
+			}
+		}
+		u.Set[ks] = nval
+		u.changes = append(u.changes, Change{Type: ChangeCreate, Path: []string{ks}, To: nval})
+	}
+	if ov.IsValid() {
+		for _, k := range ov.MapKeys() {
+			ks := k.String()
+			if ks == "_id" || seen[ks] {
+				continue
+			}
+			u.Unset[ks] = nil
+			u.changes = append(u.changes, Change{Type: ChangeDelete, Path: []string{ks}, From: ov.MapIndex(k).Interface()})
+		}
+	}
+	return u, nil
+}
+
+// bsonLeafEqual reports whether old and new are equal. Ordinary
+// values are compared with reflect.DeepEqual; values implementing
+// bson.Getter (including bson.Raw) are instead marshaled to BSON,
+// wrapped in a single-field document, and compared on their
+// serialized bytes, so that differences in representation rather
+// than content don't cause spurious updates. Marshal-and-compare
+// can't be used as the general case: mgo's bson map encoder iterates
+// map keys in Go's randomized order, so an unchanged map-valued field
+// can marshal to different bytes on different calls.
+func bsonLeafEqual(old, new interface{}) (bool, error) {
+	if !needsBSONCompare(old) && !needsBSONCompare(new) {
+		return reflect.DeepEqual(old, new), nil
+	}
+	od, err := bson.Marshal(bson.M{"v": old})
+	if err != nil {
+		return false, errgo.Notef(err, "cannot marshal old value")
+	}
+	nd, err := bson.Marshal(bson.M{"v": new})
+	if err != nil {
+		return false, errgo.Notef(err, "cannot marshal new value")
+	}
+	return bytes.Equal(od, nd), nil
+}
+
+// needsBSONCompare reports whether v must be compared on its
+// marshaled BSON form rather than by reflect.DeepEqual: true for
+// bson.Raw and any other type implementing bson.Getter, whose
+// in-memory representation doesn't determine its stored content.
+func needsBSONCompare(v interface{}) bool {
+	if v == nil {
+		return false
+	}
+	if _, ok := v.(bson.Getter); ok {
+		return true
+	}
+	_, ok := v.(bson.Raw)
+	return ok
+}
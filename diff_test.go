@@ -0,0 +1,213 @@
+// Copyright (c) 2016 - Canonical Ltd
+//
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+//    list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+// ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package mgoutil_test
+
+import (
+	gc "gopkg.in/check.v1"
+	"gopkg.in/mgo.v2/bson"
+
+	"juju/mgoutil"
+)
+
+type diffDoc struct {
+	Name    string `bson:"name"`
+	Age     int    `bson:"age,omitempty"`
+	Comment string `bson:"comment,omitempty"`
+}
+
+type diffMapFieldDoc struct {
+	Name  string            `bson:"name"`
+	Extra map[string]string `bson:"extra"`
+}
+
+var diffAsUpdateTests = []struct {
+	description string
+	old, new    interface{}
+	expect      mgoutil.Update
+	expectLog   []mgoutil.Change
+	expectError string
+}{{
+	description: "identical structs produce no changes",
+	old:         diffDoc{Name: "alice", Age: 30},
+	new:         diffDoc{Name: "alice", Age: 30},
+	expect: mgoutil.Update{
+		Set:   bson.M{},
+		Unset: bson.M{},
+	},
+}, {
+	description: "changed field is set",
+	old:         diffDoc{Name: "alice", Age: 30},
+	new:         diffDoc{Name: "alice", Age: 31},
+	expect: mgoutil.Update{
+		Set:   bson.M{"age": 31},
+		Unset: bson.M{},
+	},
+	expectLog: []mgoutil.Change{{
+		Type: mgoutil.ChangeUpdate, Path: []string{"age"}, From: 30, To: 31,
+	}},
+}, {
+	description: "newly-present omitempty field is set",
+	old:         diffDoc{Name: "alice"},
+	new:         diffDoc{Name: "alice", Comment: "hello"},
+	expect: mgoutil.Update{
+		Set:   bson.M{"comment": "hello"},
+		Unset: bson.M{},
+	},
+	expectLog: []mgoutil.Change{{
+		Type: mgoutil.ChangeCreate, Path: []string{"comment"}, To: "hello",
+	}},
+}, {
+	description: "field that became zero omitempty is unset",
+	old:         diffDoc{Name: "alice", Comment: "hello"},
+	new:         diffDoc{Name: "alice"},
+	expect: mgoutil.Update{
+		Set:   bson.M{},
+		Unset: bson.M{"comment": nil},
+	},
+	expectLog: []mgoutil.Change{{
+		Type: mgoutil.ChangeDelete, Path: []string{"comment"}, From: "hello",
+	}},
+}, {
+	description: "maps are diffed key by key",
+	old:         map[string]interface{}{"a": 1, "b": 2},
+	new:         map[string]interface{}{"a": 1, "b": 3, "c": 4},
+	expect: mgoutil.Update{
+		Set:   bson.M{"b": 3, "c": 4},
+		Unset: bson.M{},
+	},
+}, {
+	description: "key dropped from new map is unset",
+	old:         map[string]interface{}{"a": 1, "b": 2},
+	new:         map[string]interface{}{"a": 1},
+	expect: mgoutil.Update{
+		Set:   bson.M{},
+		Unset: bson.M{"b": nil},
+	},
+}, {
+	description: "mismatched types are rejected",
+	old:         diffDoc{},
+	new:         struct{ X int }{},
+	expectError: `cannot diff values of different types: .*`,
+}}
+
+func (*S) TestDiffAsUpdate(c *gc.C) {
+	for i, test := range diffAsUpdateTests {
+		c.Logf("test %d: %s", i, test.description)
+		u, err := mgoutil.DiffAsUpdate(test.old, test.new)
+		if test.expectError != "" {
+			c.Assert(err, gc.ErrorMatches, test.expectError)
+			continue
+		}
+		c.Assert(err, gc.Equals, nil)
+		c.Assert(u.Set, gc.DeepEquals, test.expect.Set)
+		c.Assert(u.Unset, gc.DeepEquals, test.expect.Unset)
+		if test.expectLog != nil {
+			c.Assert(u.Changelog(), gc.DeepEquals, test.expectLog)
+		}
+	}
+}
+
+// TestDiffAsUpdateMapFieldNotFlaky guards against bsonLeafEqual
+// marshaling a map-typed, non-inline field to compare it: mgo's bson
+// encoder iterates map keys in Go's randomized order, so an unchanged
+// map field could marshal to different bytes across calls and get
+// spuriously reported as changed.
+func (*S) TestDiffAsUpdateMapFieldNotFlaky(c *gc.C) {
+	extra := map[string]string{"a": "1", "b": "2", "c": "3", "d": "4"}
+	old := &diffMapFieldDoc{Name: "alice", Extra: extra}
+	new := &diffMapFieldDoc{Name: "alice", Extra: map[string]string{"a": "1", "b": "2", "c": "3", "d": "4"}}
+	for i := 0; i < 20; i++ {
+		u, err := mgoutil.DiffAsUpdate(old, new)
+		c.Assert(err, gc.Equals, nil)
+		c.Assert(u.Set, gc.DeepEquals, bson.M{})
+		c.Assert(u.Unset, gc.DeepEquals, bson.M{})
+	}
+
+	new.Extra = map[string]string{"a": "1", "b": "changed", "c": "3", "d": "4"}
+	u, err := mgoutil.DiffAsUpdate(old, new)
+	c.Assert(err, gc.Equals, nil)
+	c.Assert(u.Set, gc.DeepEquals, bson.M{"extra": new.Extra})
+}
+
+func (*S) TestDiffAsUpdateInlineMap(c *gc.C) {
+	old := &inlineMap{A: 1, M: map[string]interface{}{"b": 2}}
+	new := &inlineMap{A: 1, M: map[string]interface{}{"b": 3, "c": 4}}
+	u, err := mgoutil.DiffAsUpdate(old, new)
+	c.Assert(err, gc.Equals, nil)
+	c.Assert(u.Set, gc.DeepEquals, bson.M{"b": 3, "c": 4})
+	c.Assert(u.Unset, gc.DeepEquals, bson.M{})
+}
+
+// TestDiffAsUpdateVersion guards against DiffAsUpdate treating a
+// "version" tagged field as a plain $set: it must always route
+// through $inc and populate Selector from old, exactly as AsUpdate
+// does, so UpdateWithCAS keeps working against a diff-built Update.
+func (*S) TestDiffAsUpdateVersion(c *gc.C) {
+	old := &versionedDoc{Name: "alice", Rev: 4}
+	new := &versionedDoc{Name: "alice", Rev: 4}
+	u, err := mgoutil.DiffAsUpdate(old, new)
+	c.Assert(err, gc.Equals, nil)
+	c.Assert(u.Inc, gc.DeepEquals, bson.M{"rev": 1})
+	c.Assert(u.Selector, gc.DeepEquals, bson.M{"rev": 4})
+	c.Assert(u.Set, gc.DeepEquals, bson.M{})
+	c.Assert(u.Changelog(), gc.DeepEquals, []mgoutil.Change{{
+		Type: mgoutil.ChangeUpdate, Path: []string{"rev"}, To: 1,
+	}})
+}
+
+// TestDiffAsUpdateOperators checks that the other operator tags
+// introduced for AsUpdate are likewise routed to their operator maps,
+// rather than compared and placed in Set, when going through
+// DiffAsUpdate.
+func (*S) TestDiffAsUpdateOperators(c *gc.C) {
+	old := &opsDoc{}
+	new := &opsDoc{
+		Views:       1,
+		Tag:         "x",
+		Tags:        []string{"a", "b"},
+		LogEntry:    "started",
+		RemovedTags: []string{"c", "d"},
+	}
+	u, err := mgoutil.DiffAsUpdate(old, new)
+	c.Assert(err, gc.Equals, nil)
+	c.Assert(u.Inc, gc.DeepEquals, bson.M{"views": 1})
+	c.Assert(u.Push, gc.DeepEquals, bson.M{"log": "started"})
+	c.Assert(u.AddToSet, gc.DeepEquals, bson.M{
+		"tag":  "x",
+		"tags": bson.M{"$each": []string{"a", "b"}},
+	})
+	c.Assert(u.Pull, gc.DeepEquals, bson.M{
+		"removedTags": bson.M{"$in": []string{"c", "d"}},
+	})
+	c.Assert(u.CurrentDate, gc.DeepEquals, bson.M{"updatedAt": true})
+	c.Assert(u.Changelog(), gc.DeepEquals, []mgoutil.Change{
+		{Type: mgoutil.ChangeUpdate, Path: []string{"views"}, To: 1},
+		{Type: mgoutil.ChangeUpdate, Path: []string{"tag"}, To: "x"},
+		{Type: mgoutil.ChangeUpdate, Path: []string{"tags"}, To: bson.M{"$each": []string{"a", "b"}}},
+		{Type: mgoutil.ChangeUpdate, Path: []string{"log"}, To: "started"},
+		{Type: mgoutil.ChangeUpdate, Path: []string{"removedTags"}, To: bson.M{"$in": []string{"c", "d"}}},
+		{Type: mgoutil.ChangeUpdate, Path: []string{"updatedAt"}, To: true},
+	})
+}
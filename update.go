@@ -35,14 +35,78 @@ import (
 
 // Update represents a document update operation. When marshaled and
 // provided to an update operation, it will set all the fields in Set
-// and unset all the fields in Unset.
+// and unset all the fields in Unset, along with applying any of the
+// other operators below that hold entries.
 type Update struct {
 	// Set holds the fields to be set keyed by field name.
-	Set map[string]interface{} `bson:"$set,omitempty"`
+	Set bson.M `bson:"$set,omitempty"`
 
 	// Unset holds the fields to be unset keyed by field name. Note that
 	// the key values will be ignored.
-	Unset map[string]interface{} `bson:"$unset,omitempty"`
+	Unset bson.M `bson:"$unset,omitempty"`
+
+	// Inc holds the fields to be incremented, keyed by field name,
+	// populated from struct fields tagged with the "inc" bson flag.
+	Inc bson.M `bson:"$inc,omitempty"`
+
+	// Push holds the fields to append values to, keyed by field
+	// name, populated from struct fields tagged with the "push"
+	// bson flag. A slice-valued field is wrapped in "$each" so that
+	// all its elements are appended.
+	Push bson.M `bson:"$push,omitempty"`
+
+	// Pull holds the fields to remove values from, keyed by field
+	// name, populated from struct fields tagged with the "pull"
+	// bson flag. A slice-valued field is wrapped in "$in" so that
+	// all its elements are removed; $pull has no "$each" modifier.
+	Pull bson.M `bson:"$pull,omitempty"`
+
+	// AddToSet holds the fields to add distinct values to, keyed by
+	// field name, populated from struct fields tagged with the
+	// "addToSet" bson flag. A slice-valued field is wrapped in
+	// "$each" so that all its elements are added.
+	AddToSet bson.M `bson:"$addToSet,omitempty"`
+
+	// CurrentDate holds the fields to set to the server's current
+	// date, keyed by field name, populated from struct fields
+	// tagged with the "currentDate" bson flag. The tagged field's
+	// own value is not consulted.
+	CurrentDate bson.M `bson:"$currentDate,omitempty"`
+
+	// Selector holds the observed value of the struct field tagged
+	// with the "version" bson flag, if any, keyed by field name. It
+	// is not part of the update document itself; callers combine it
+	// with their own _id filter to perform a compare-and-swap
+	// update, as UpdateWithCAS does.
+	Selector bson.M `bson:"-"`
+
+	// changes holds the changelog entries describing how this Update
+	// was derived, as reported by Changelog. It is only populated by
+	// DiffAsUpdate.
+	changes []Change
+}
+
+// resolveValue dereferences x through any bson.Getter and pointer
+// indirections, returning the underlying value that AsUpdate and
+// DiffAsUpdate should reflect over.
+func resolveValue(x interface{}) (reflect.Value, error) {
+	v := reflect.ValueOf(x)
+	for {
+		if vi, ok := v.Interface().(bson.Getter); ok {
+			getv, err := vi.GetBSON()
+			if err != nil {
+				return reflect.Value{}, fmt.Errorf("GetBSON failed: %v", err)
+			}
+			v = reflect.ValueOf(getv)
+			continue
+		}
+		if v.Kind() == reflect.Ptr {
+			v = v.Elem()
+			continue
+		}
+		break
+	}
+	return v, nil
 }
 
 // AsUpdate returns the given object as an Update value holding all the
@@ -51,6 +115,24 @@ type Update struct {
 // Set. On success, the returned Set and Unset fields will always
 // be non-nil, even when they contain no items.
 //
+// Struct fields may opt into a different update operator instead of
+// $set/$unset by adding one of "inc", "push", "pull", "addToSet" or
+// "currentDate" to their bson tag, e.g. `bson:"views,inc"`; such
+// fields are routed to the matching Inc, Push, Pull, AddToSet or
+// CurrentDate map instead.
+//
+// A field tagged "version" instead, e.g. `bson:"rev,version"`, is
+// incremented via $inc rather than set, and its observed value is
+// reported in the returned Update's Selector field for optimistic
+// concurrency control; see UpdateWithCAS.
+//
+// Before doing any of the above, AsUpdate validates x: if it (or its
+// address) implements Validator, Validate is called, and every
+// function registered with RegisterValidator is run, including the
+// built-in check of "validate" struct tags such as
+// `validate:"required,min=1,email"`. The first validation error
+// found aborts AsUpdate.
+//
 // Note that the _id field is omitted, as it is not possible to set this
 // in an update operation.
 //
@@ -74,28 +156,17 @@ type Update struct {
 // mentioned in x. If there are other fields stored, they won't
 // be affected.
 func AsUpdate(x interface{}) (Update, error) {
-	v := reflect.ValueOf(x)
-	for {
-		if vi, ok := v.Interface().(bson.Getter); ok {
-			getv, err := vi.GetBSON()
-			if err != nil {
-				return Update{}, fmt.Errorf("GetBSON failed: %v", err)
-				panic(err)
-			}
-			v = reflect.ValueOf(getv)
-			continue
-		}
-		if v.Kind() == reflect.Ptr {
-			v = v.Elem()
-			continue
-		}
-		break
+	v, err := resolveValue(x)
+	if err != nil {
+		return Update{}, err
+	}
+	if err := runValidators(v); err != nil {
+		return Update{}, err
 	}
 	if v.Type() == typeRaw {
 		return nonStructAsUpdate(v.Addr())
 	}
 	var u Update
-	var err error
 	switch t := v.Type(); t.Kind() {
 	case reflect.Map:
 		u, err = mapAsUpdate(v)
@@ -139,15 +210,106 @@ func structAsUpdate(v reflect.Value) (Update, error) {
 		} else {
 			value = v.FieldByIndex(info.Inline)
 		}
-		if info.OmitEmpty && isZero(value) {
-			u.Unset[info.Key] = nil
-		} else {
-			u.Set[info.Key] = value.Interface()
+		absent := info.OmitEmpty && isZero(value)
+		var iface interface{}
+		// $currentDate ignores the field's own value, so there's no
+		// need to read it at all.
+		needValue := info.Op != updateOpCurrentDate
+		if needValue && (!absent || info.Op != "") {
+			iface = value.Interface()
+		}
+		if info.Op == "" {
+			if absent {
+				u.Unset[info.Key] = nil
+			} else {
+				u.Set[info.Key] = iface
+			}
+			continue
 		}
+		applyOp(&u, info, iface, iface)
 	}
 	return u, nil
 }
 
+// applyOp routes a single operator-tagged field to the matching
+// operator map on u. opValue supplies the operator's argument for
+// every operator except "version", which ignores it in favour of
+// always incrementing by one; versionValue supplies the CAS guard's
+// observed value reported in Update.Selector, which structAsUpdate
+// and structDiffAsUpdate source differently (the field's own current
+// value, and old's value, respectively). It returns the value placed
+// into the operator map (or, for "version", the $inc amount) so
+// callers tracking a changelog can report it.
+func applyOp(u *Update, info fieldInfo, opValue, versionValue interface{}) interface{} {
+	switch info.Op {
+	case updateOpVersion:
+		if u.Inc == nil {
+			u.Inc = make(bson.M)
+		}
+		u.Inc[info.Key] = 1
+		if u.Selector == nil {
+			u.Selector = make(bson.M)
+		}
+		u.Selector[info.Key] = versionValue
+		return 1
+	case updateOpInc:
+		if u.Inc == nil {
+			u.Inc = make(bson.M)
+		}
+		u.Inc[info.Key] = opValue
+		return opValue
+	case updateOpPush:
+		if u.Push == nil {
+			u.Push = make(bson.M)
+		}
+		v := eachValue(opValue)
+		u.Push[info.Key] = v
+		return v
+	case updateOpPull:
+		if u.Pull == nil {
+			u.Pull = make(bson.M)
+		}
+		v := pullValue(opValue)
+		u.Pull[info.Key] = v
+		return v
+	case updateOpAddToSet:
+		if u.AddToSet == nil {
+			u.AddToSet = make(bson.M)
+		}
+		v := eachValue(opValue)
+		u.AddToSet[info.Key] = v
+		return v
+	case updateOpCurrentDate:
+		if u.CurrentDate == nil {
+			u.CurrentDate = make(bson.M)
+		}
+		u.CurrentDate[info.Key] = true
+		return true
+	}
+	return nil
+}
+
+// eachValue returns the value to use for a $push/$addToSet operator
+// entry: a slice-valued field is wrapped in "$each" so that all of
+// its elements are applied, rather than the slice itself.
+func eachValue(iface interface{}) interface{} {
+	if reflect.ValueOf(iface).Kind() == reflect.Slice {
+		return bson.M{"$each": iface}
+	}
+	return iface
+}
+
+// pullValue returns the value to use for a $pull operator entry.
+// Unlike $push/$addToSet, $pull has no "$each" modifier, so a
+// slice-valued field is instead wrapped in "$in" to remove every
+// element that matches one of its values.
+func pullValue(iface interface{}) interface{} {
+	if reflect.ValueOf(iface).Kind() == reflect.Slice {
+		return bson.M{"$in": iface}
+	}
+	return iface
+}
+
 func nonStructAsUpdate(v reflect.Value) (Update, error) {
 	var m map[string]bson.Raw
 	data, err := bson.Marshal(v.Interface())
@@ -0,0 +1,77 @@
+// Copyright (c) 2016 - Canonical Ltd
+//
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+//    list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+// ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package mgoutil_test
+
+import (
+	gc "gopkg.in/check.v1"
+	"gopkg.in/mgo.v2/bson"
+
+	"juju/mgoutil"
+)
+
+type opsDoc struct {
+	Views       int      `bson:"views,inc"`
+	Tag         string   `bson:"tag,addToSet"`
+	Tags        []string `bson:"tags,addToSet"`
+	LogEntry    string   `bson:"log,push"`
+	RemovedTags []string `bson:"removedTags,pull"`
+	UpdatedAt   bool     `bson:"updatedAt,currentDate"`
+}
+
+func (*S) TestAsUpdateOperators(c *gc.C) {
+	doc := &opsDoc{
+		Views:       1,
+		Tag:         "x",
+		Tags:        []string{"a", "b"},
+		LogEntry:    "started",
+		RemovedTags: []string{"c", "d"},
+	}
+	u, err := mgoutil.AsUpdate(doc)
+	c.Assert(err, gc.Equals, nil)
+	c.Assert(u.Inc, gc.DeepEquals, bson.M{"views": 1})
+	c.Assert(u.Push, gc.DeepEquals, bson.M{"log": "started"})
+	c.Assert(u.AddToSet, gc.DeepEquals, bson.M{
+		"tag":  "x",
+		"tags": bson.M{"$each": []string{"a", "b"}},
+	})
+	c.Assert(u.Pull, gc.DeepEquals, bson.M{
+		"removedTags": bson.M{"$in": []string{"c", "d"}},
+	})
+	c.Assert(u.CurrentDate, gc.DeepEquals, bson.M{"updatedAt": true})
+}
+
+type versionedDoc struct {
+	Name string `bson:"name"`
+	Rev  int    `bson:"rev,version"`
+}
+
+func (*S) TestAsUpdateVersion(c *gc.C) {
+	doc := &versionedDoc{Name: "alice", Rev: 4}
+	u, err := mgoutil.AsUpdate(doc)
+	c.Assert(err, gc.Equals, nil)
+	c.Assert(u.Inc, gc.DeepEquals, bson.M{"rev": 1})
+	c.Assert(u.Selector, gc.DeepEquals, bson.M{"rev": 4})
+	c.Assert(u.Set, gc.DeepEquals, bson.M{"name": "alice"})
+}
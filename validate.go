@@ -0,0 +1,241 @@
+// Copyright (c) 2016 - Canonical Ltd
+//
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+//    list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+// ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package mgoutil
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Validator is implemented by types that can validate their own
+// state. If the value passed to AsUpdate (after resolving any
+// bson.Getter and pointer indirection) implements Validator, AsUpdate
+// calls Validate and fails if it returns an error.
+type Validator interface {
+	Validate() error
+}
+
+var (
+	validatorsMutex sync.RWMutex
+	validators      []func(reflect.Value) error
+)
+
+// RegisterValidator adds fn to the set of validator functions invoked
+// by AsUpdate on the resolved value before it does any BSON
+// reflection. It is usually called from an init function.
+func RegisterValidator(fn func(reflect.Value) error) {
+	validatorsMutex.Lock()
+	defer validatorsMutex.Unlock()
+	validators = append(validators, fn)
+}
+
+func init() {
+	RegisterValidator(validateStructTags)
+}
+
+// runValidators invokes the Validator interface, if implemented by v
+// or its address, followed by every function registered with
+// RegisterValidator, in registration order, stopping at the first
+// error.
+func runValidators(v reflect.Value) error {
+	if vi, ok := validatorInterface(v); ok {
+		if err := vi.Validate(); err != nil {
+			return err
+		}
+	}
+	validatorsMutex.RLock()
+	fns := validators
+	validatorsMutex.RUnlock()
+	for _, fn := range fns {
+		if err := fn(v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func validatorInterface(v reflect.Value) (Validator, bool) {
+	if v.CanAddr() {
+		if vi, ok := v.Addr().Interface().(Validator); ok {
+			return vi, true
+		}
+	}
+	if v.IsValid() {
+		if vi, ok := v.Interface().(Validator); ok {
+			return vi, true
+		}
+	}
+	return nil, false
+}
+
+// FieldError reports that a single field failed a validate tag rule.
+type FieldError struct {
+	// Path holds the struct field's bson key.
+	Path string
+	Err  error
+}
+
+func (e *FieldError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Path, e.Err)
+}
+
+// ValidationErrors collects the FieldErrors found while validating a
+// struct's "validate" tags. It is returned by validateStructTags, and
+// so may be returned by AsUpdate, whenever one or more fields fail
+// their rules.
+type ValidationErrors []*FieldError
+
+func (e ValidationErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, fe := range e {
+		msgs[i] = fe.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// validateStructTags is registered as a validator by default. It
+// walks the fields of a struct value, checking each against the
+// rules compiled from its "validate" tag, e.g. `validate:"required,min=1,email"`,
+// and returns a ValidationErrors naming every field that failed.
+func validateStructTags(v reflect.Value) error {
+	if v.Kind() != reflect.Struct || v.Type() == typeTime {
+		return nil
+	}
+	sinfo, err := getStructInfo(v.Type())
+	if err != nil {
+		return err
+	}
+	var errs ValidationErrors
+	var value reflect.Value
+	for _, info := range sinfo.FieldsList {
+		if len(info.Rules) == 0 {
+			continue
+		}
+		if info.Inline == nil {
+			value = v.Field(info.Num)
+		} else {
+			value = v.FieldByIndex(info.Inline)
+		}
+		if info.OmitEmpty && isZero(value) {
+			// An absent omitempty field is unset rather than written
+			// (see AsUpdate), so it isn't a validation failure: only
+			// non-omitempty and present fields are checked against
+			// their rules.
+			continue
+		}
+		for _, rule := range info.Rules {
+			if err := rule.check(value); err != nil {
+				errs = append(errs, &FieldError{Path: info.Key, Err: err})
+				break
+			}
+		}
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+// validateRule is a single compiled "validate" tag rule, such as
+// "required" or "min=1".
+type validateRule struct {
+	name  string
+	param string
+}
+
+var emailRE = regexp.MustCompile(`^[^\s@]+@[^\s@]+\.[^\s@]+$`)
+
+func parseValidateTag(tag string) ([]validateRule, error) {
+	parts := strings.Split(tag, ",")
+	rules := make([]validateRule, 0, len(parts))
+	for _, part := range parts {
+		if part == "" {
+			continue
+		}
+		name, param := part, ""
+		if i := strings.Index(part, "="); i >= 0 {
+			name, param = part[:i], part[i+1:]
+		}
+		switch name {
+		case "required", "email":
+			if param != "" {
+				return nil, fmt.Errorf("rule %q takes no parameter", name)
+			}
+		case "min", "max":
+			if _, err := strconv.ParseFloat(param, 64); err != nil {
+				return nil, fmt.Errorf("rule %q needs a numeric parameter: %v", name, err)
+			}
+		default:
+			return nil, fmt.Errorf("unknown validate rule %q", name)
+		}
+		rules = append(rules, validateRule{name: name, param: param})
+	}
+	return rules, nil
+}
+
+func (r validateRule) check(v reflect.Value) error {
+	switch r.name {
+	case "required":
+		if isZero(v) {
+			return errors.New("required field is empty")
+		}
+	case "email":
+		if v.Kind() != reflect.String || !emailRE.MatchString(v.String()) {
+			return errors.New("not a valid email address")
+		}
+	case "min", "max":
+		return r.checkBound(v)
+	}
+	return nil
+}
+
+func (r validateRule) checkBound(v reflect.Value) error {
+	bound, _ := strconv.ParseFloat(r.param, 64)
+	var got float64
+	switch v.Kind() {
+	case reflect.String, reflect.Slice, reflect.Map, reflect.Array:
+		got = float64(v.Len())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		got = float64(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		got = float64(v.Uint())
+	case reflect.Float32, reflect.Float64:
+		got = v.Float()
+	default:
+		return fmt.Errorf("rule %q does not support field of kind %s", r.name, v.Kind())
+	}
+	if r.name == "min" && got < bound {
+		return fmt.Errorf("must be at least %v, got %v", bound, got)
+	}
+	if r.name == "max" && got > bound {
+		return fmt.Errorf("must be at most %v, got %v", bound, got)
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+// Copyright (c) 2016 - Canonical Ltd
+//
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+//    list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE LIABLE FOR
+// ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+// ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package mgoutil_test
+
+import (
+	"errors"
+
+	gc "gopkg.in/check.v1"
+
+	"juju/mgoutil"
+)
+
+type validateDoc struct {
+	Name  string `bson:"name" validate:"required"`
+	Email string `bson:"email,omitempty" validate:"email"`
+	Age   int    `bson:"age" validate:"min=0,max=150"`
+}
+
+var validateStructTagTests = []struct {
+	description string
+	doc         validateDoc
+	expectError string
+}{{
+	description: "valid document passes",
+	doc:         validateDoc{Name: "alice", Age: 30},
+}, {
+	description: "valid document with optional email passes",
+	doc:         validateDoc{Name: "alice", Email: "alice@example.com", Age: 30},
+}, {
+	description: "empty required field fails",
+	doc:         validateDoc{Age: 30},
+	expectError: `name: required field is empty`,
+}, {
+	description: "absent omitempty field is not checked",
+	doc:         validateDoc{Name: "alice", Age: 30},
+}, {
+	description: "present but invalid email fails",
+	doc:         validateDoc{Name: "alice", Email: "not-an-email", Age: 30},
+	expectError: `email: not a valid email address`,
+}, {
+	description: "out of bound age fails",
+	doc:         validateDoc{Name: "alice", Age: 200},
+	expectError: `age: must be at most 150, got 200`,
+}, {
+	description: "multiple failures are all reported",
+	doc:         validateDoc{Email: "not-an-email", Age: 200},
+	expectError: `name: required field is empty; email: not a valid email address; age: must be at most 150, got 200`,
+}}
+
+func (*S) TestValidateStructTags(c *gc.C) {
+	for i, test := range validateStructTagTests {
+		c.Logf("test %d: %s", i, test.description)
+		_, err := mgoutil.AsUpdate(&test.doc)
+		if test.expectError != "" {
+			c.Assert(err, gc.ErrorMatches, test.expectError)
+		} else {
+			c.Assert(err, gc.Equals, nil)
+		}
+	}
+}
+
+type selfValidatingDoc struct {
+	X int
+}
+
+func (d *selfValidatingDoc) Validate() error {
+	if d.X < 0 {
+		return errors.New("X must not be negative")
+	}
+	return nil
+}
+
+func (*S) TestValidatorInterface(c *gc.C) {
+	_, err := mgoutil.AsUpdate(&selfValidatingDoc{X: 1})
+	c.Assert(err, gc.Equals, nil)
+
+	_, err = mgoutil.AsUpdate(&selfValidatingDoc{X: -1})
+	c.Assert(err, gc.ErrorMatches, `X must not be negative`)
+}
+
+// TestDiffAsUpdateRunsValidators checks that DiffAsUpdate validates
+// new the same way AsUpdate does, rather than bypassing the
+// "validate" tag and RegisterValidator hooks entirely.
+func (*S) TestDiffAsUpdateRunsValidators(c *gc.C) {
+	old := validateDoc{Name: "alice", Age: 30}
+	new := validateDoc{Age: 200}
+	_, err := mgoutil.DiffAsUpdate(&old, &new)
+	c.Assert(err, gc.ErrorMatches, `name: required field is empty; age: must be at most 150, got 200`)
+}